@@ -2,27 +2,35 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	batchv1 "k8s.io/api/batch/v1"
 	coreapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runMain(os.Args[2:]))
+	}
+
 	var kubeconfig *string
 	if home := homedir.HomeDir(); home != "" {
 		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
@@ -30,71 +38,120 @@ func main() {
 		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
 	}
 	var (
-		jobNames  StringSlice
-		namespace string
+		jobNames    StringSlice
+		namespace   string
+		sinkSpec    string
+		logDir      string
+		metricsAddr string
 	)
 	flag.Var(&jobNames, "j", "Cron job name (required, can be provided multiple times).")
 	flag.StringVar(&namespace, "n", "", "Kubernetes namespace (required).")
+	flag.StringVar(&sinkSpec, "sink", "file", "Where to send pod logs and status: file, stdout, rotate, or s3://bucket/prefix.")
+	flag.StringVar(&logDir, "log-dir", "", "Directory to write logs and status to when --sink=file (default: current directory).")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve /metrics, /healthz, and /readyz on, e.g. :9090 (disabled if empty).")
 	flag.Parse()
 
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// use the current context in kubeconfig
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
-		panic(err)
+		log.Fatalf("building kubeconfig: %v", err)
 	}
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err)
+		log.Fatalf("building kubernetes client: %v", err)
+	}
+	logSink, statusSink, err := parseSinkSpec(sinkSpec, logDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grp, ctx := errgroup.WithContext(signalCtx)
+
+	h := newHealth()
+	if metricsAddr != "" {
+		serveMetrics(ctx, metricsAddr, h)
 	}
-	grp, ctx := errgroup.WithContext(context.Background())
 
 	for _, jobName := range jobNames {
 		job := jobName
 		grp.Go(func() error {
-			return watch(ctx, job, namespace, client)
+			return watch(ctx, job, namespace, client, logSink, statusSink, h)
 		})
 	}
-	if err := grp.Wait(); err != nil {
-		panic(err)
+	// A cancellation caused by an interrupt/TERM is a clean shutdown, not a
+	// failure; only a non-nil error with no pending signal is fatal.
+	if err := grp.Wait(); err != nil && signalCtx.Err() == nil {
+		log.Fatal(err)
 	}
 }
 
-func watch(ctx context.Context, cronJobName, namespace string, client *kubernetes.Clientset) error {
+// watch drives cronJobName's state machine off a CronJob informer instead of
+// polling Get every few seconds: each time the informer observes the cron
+// job's .status.active change, any job name we haven't seen yet is handed to
+// monitor in its own goroutine. This also lets multiple active jobs (a
+// CronJob with concurrencyPolicy: Allow) be monitored correctly instead of
+// panicking.
+func watch(ctx context.Context, cronJobName, namespace string, client *kubernetes.Clientset, logSink LogSink, statusSink StatusSink, h *health) error {
 	log.Printf("monitoring %s", cronJobName)
+	defer h.setNotReady()
 
-	var activeJob string
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+	cronJobs := factory.Batch().V1().CronJobs().Informer()
 
-GetJobsLoop:
-	for {
-		cronJob, err := client.BatchV1().CronJobs(namespace).Get(ctx, cronJobName, metav1.GetOptions{})
-		if err != nil {
-			panic(err)
-		}
-		numActive := len(cronJob.Status.Active)
+	grp, ctx := errgroup.WithContext(ctx)
 
-		if numActive == 0 {
-			time.Sleep(5 * time.Second)
-			continue GetJobsLoop
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	startMonitor := func(jobName string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[jobName] {
+			return
 		}
-		if numActive != 1 {
-			panic(fmt.Errorf("expected only 1 active cron job at a time, got %d", numActive))
+		seen[jobName] = true
+		grp.Go(func() error {
+			return monitor(ctx, client, namespace, jobName, logSink, statusSink)
+		})
+	}
+	onCronJobEvent := func(obj interface{}) {
+		cronJob, ok := obj.(*batchv1.CronJob)
+		if !ok || cronJob.Name != cronJobName {
+			return
 		}
-		if newActiveJob := cronJob.Status.Active[0].Name; newActiveJob != activeJob {
-			// Never seen this job before.
-			// Start a goroutine that will monitor this job.
-			// TODO: wait for goroutines to finish if the program gets killed.
-			activeJob = newActiveJob
-			if err := monitor(ctx, client, namespace, activeJob); err != nil {
-				panic(err)
-			}
+		cronJobActive.WithLabelValues(cronJobName, namespace).Set(float64(len(cronJob.Status.Active)))
+		for _, active := range cronJob.Status.Active {
+			startMonitor(active.Name)
 		}
-		time.Sleep(5 * time.Second)
 	}
+	if _, err := cronJobs.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onCronJobEvent,
+		UpdateFunc: func(_, obj interface{}) { onCronJobEvent(obj) },
+	}); err != nil {
+		apiErrorsTotal.WithLabelValues("watch_cronjobs").Inc()
+		return fmt.Errorf("registering cron job event handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), cronJobs.HasSynced) {
+		apiErrorsTotal.WithLabelValues("watch_cronjobs").Inc()
+		return fmt.Errorf("timed out waiting for cron job informer cache to sync")
+	}
+
+	<-ctx.Done()
+	return grp.Wait()
 }
 
-func monitor(ctx context.Context, client *kubernetes.Clientset, namespace, jobName string) error {
+func monitor(ctx context.Context, client *kubernetes.Clientset, namespace, jobName string, logSink LogSink, statusSink StatusSink) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	job, err := client.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
 	if err != nil {
+		apiErrorsTotal.WithLabelValues("get_job").Inc()
 		return fmt.Errorf("getting job: %w", err)
 	}
 	var labelSelector, sep string
@@ -103,81 +160,65 @@ func monitor(ctx context.Context, client *kubernetes.Clientset, namespace, jobNa
 		labelSelector += sep + k + "=" + v
 		sep = ","
 	}
-	pods := client.CoreV1().Pods(namespace)
-
-ListPods:
-	for {
-		select {
-		case <-ctx.Done():
-		default:
-			podList, err := pods.List(ctx, metav1.ListOptions{
-				LabelSelector: labelSelector,
-			})
-			if err != nil {
-				return fmt.Errorf("listing pods: %w", err)
-			}
-			// If there are no running pods with the label we want then sleep and continue.
-			runningPod, hasRunningPod := getRunning(podList)
-			if !hasRunningPod {
-				time.Sleep(50 * time.Millisecond)
-				continue ListPods
-			}
-			// Start tailing the logs.
-			stream, err := pods.GetLogs(runningPod.Name, &coreapi.PodLogOptions{Follow: true}).Stream(ctx)
-			if err != nil {
-				return fmt.Errorf("getting log stream: %w", err)
-			}
-			filename := runningPod.Name + ".logs"
-			logFile, err := os.Create(filename)
-			if err != nil {
-				return fmt.Errorf("creating logg file: %w", err)
-			}
-			defer logFile.Close()
+	logWriter, err := logSink.Open(jobName)
+	if err != nil {
+		return fmt.Errorf("opening log sink: %w", err)
+	}
+	defer logWriter.Close()
 
-			if _, err := io.Copy(logFile, stream); err != nil {
-				return fmt.Errorf("streaming logs: %w", err)
+	jobs := client.BatchV1().Jobs(namespace)
+	jobDone := func(ctx context.Context) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		final, err := jobs.Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			apiErrorsTotal.WithLabelValues("get_job").Inc()
+			return false, fmt.Errorf("getting job: %w", err)
+		}
+		for _, cond := range final.Status.Conditions {
+			if cond.Status != coreapi.ConditionTrue {
+				continue
 			}
-			// Sometimes the pod still has running status after the log stream
-			// is closed, so we wait until we see something other than running.
-			var final *coreapi.Pod
-
-		PodFinishing:
-			for {
-				final, err = pods.Get(ctx, runningPod.Name, metav1.GetOptions{})
-				if err != nil {
-					return fmt.Errorf("getting pod: %w", err)
+			switch cond.Type {
+			case batchv1.JobComplete, batchv1.JobFailed:
+				result := "complete"
+				if cond.Type == batchv1.JobFailed {
+					result = "failed"
 				}
-				if final.Status.Phase == coreapi.PodRunning {
-					continue PodFinishing
-				}
-				time.Sleep(50 * time.Millisecond)
-				break
-			}
-			if final.Status.Phase == coreapi.PodFailed {
-				log.Printf("pod %s failed", runningPod.Name)
-			}
-			statusFile, err := os.Create(runningPod.Name + ".json")
-			if err != nil {
-				return fmt.Errorf("creating pod status file: %w", err)
-			}
-			defer statusFile.Close()
-
-			if err := json.NewEncoder(statusFile).Encode(final); err != nil {
-				return fmt.Errorf("writing pod status file: %w", err)
+				jobResult(jobName, namespace, result, final.Status.StartTime, final.Status.CompletionTime)
+				return true, statusSink.Write(jobName, final)
 			}
-			return nil
 		}
+		return false, nil
 	}
-}
 
-// getRunning will return the first running pod in the list and true, otherwise an empty Pod struct and false.
-func getRunning(podList *coreapi.PodList) (coreapi.Pod, bool) {
-	for _, pod := range podList.Items {
-		if phase := pod.Status.Phase; phase == coreapi.PodRunning {
-			return pod, true
+	prefixLines := job.Spec.Parallelism != nil && *job.Spec.Parallelism > 1
+	watcher := NewPodWatcher(client, namespace, jobName, labelSelector, logWriter, prefixLines)
+	runErr := watcher.Run(ctx, jobDone)
+
+	if runErr != nil && ctx.Err() != nil {
+		// We're shutting down with the job still in flight: flush whatever
+		// logs made it to the sink and record the last-known status rather
+		// than silently dropping the tail of the job's output.
+		if syncer, ok := logWriter.(interface{ Sync() error }); ok {
+			if err := syncer.Sync(); err != nil {
+				log.Printf("flushing logs for %s: %v", jobName, err)
+			}
+		}
+		// Use a short-lived, signal-independent context since ctx is
+		// already cancelled.
+		statusCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if final, err := jobs.Get(statusCtx, jobName, metav1.GetOptions{}); err == nil {
+			if err := statusSink.Write(jobName, final); err != nil {
+				log.Printf("writing status for %s on shutdown: %v", jobName, err)
+			}
+		} else {
+			log.Printf("getting job %s on shutdown: %v", jobName, err)
 		}
 	}
-	return coreapi.Pod{}, false
+	return runErr
 }
 
 type StringSlice []string
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	cronJobActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kmon_cronjob_active",
+		Help: "Number of active Jobs owned by a CronJob kmon is watching.",
+	}, []string{"name", "namespace"})
+
+	jobRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kmon_job_runs_total",
+		Help: "Jobs that reached a terminal phase, by result.",
+	}, []string{"name", "namespace", "result"})
+
+	podLogBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kmon_pod_log_bytes_total",
+		Help: "Bytes of pod log output streamed to the configured sink.",
+	}, []string{"name", "namespace"})
+
+	apiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kmon_api_errors_total",
+		Help: "Kubernetes API errors encountered, by operation.",
+	}, []string{"op"})
+
+	jobDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kmon_job_duration_seconds",
+		Help:    "Duration of monitored Jobs, from StartTime to CompletionTime.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12), // 1s .. 2048s (~34m)
+	}, []string{"name", "namespace"})
+)
+
+// health tracks whether kmon's watcher goroutines are still running, for
+// the /healthz and /readyz endpoints. A kmon process watches one CronJob
+// per -j flag; readiness is lost as soon as any one of those watchers
+// exits, since from then on that cron job is no longer being monitored.
+type health struct {
+	mu    sync.Mutex
+	ready bool
+}
+
+func newHealth() *health {
+	return &health{ready: true}
+}
+
+func (h *health) setNotReady() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = false
+}
+
+func (h *health) isReady() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+// serveMetrics starts an HTTP server exposing /metrics, /healthz, and
+// /readyz on addr, and stops it when ctx is cancelled. Liveness tracks
+// whether ctx (and so the errgroup driving all the watchers) is still
+// running; readiness additionally tracks h.
+func serveMetrics(ctx context.Context, addr string, h *health) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if ctx.Err() != nil {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.isReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutting down metrics server: %v", err)
+		}
+	}()
+	go func() {
+		log.Printf("serving metrics on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
+}
+
+// jobResult records a terminal Job outcome, incrementing kmon_job_runs_total
+// and kmon_job_duration_seconds when the Job reports a StartTime and
+// CompletionTime.
+func jobResult(name, namespace, result string, start, completion *metav1.Time) {
+	jobRunsTotal.WithLabelValues(name, namespace, result).Inc()
+	if start != nil && completion != nil {
+		jobDurationSeconds.WithLabelValues(name, namespace).Observe(completion.Sub(start.Time).Seconds())
+	}
+}
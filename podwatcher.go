@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	coreapi "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// concurrentWriter wraps an io.Writer with a mutex so that Write calls
+// from multiple pods' log streams never interleave with each other. Lines
+// are kept intact only as far as each Write carries whole lines; a line
+// split across two Writes can have another pod's output between its parts.
+type concurrentWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (cw *concurrentWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.w.Write(p)
+}
+
+// PodWatcher watches the Pods belonging to a Job and multiplexes their logs
+// into a single destination. Unlike a one-shot "find the running pod and
+// io.Copy its logs" flow, PodWatcher copes with Jobs that have
+// parallelism > 1, a restartPolicy of Never (where a failed Pod is replaced
+// by a successor), and Pods recreated after a node eviction.
+type PodWatcher struct {
+	client        kubernetes.Interface
+	namespace     string
+	jobName       string
+	labelSelector string
+	out           *concurrentWriter
+	prefix        bool
+
+	mu    sync.Mutex
+	state map[string]coreapi.PodPhase
+}
+
+// NewPodWatcher returns a PodWatcher that streams the logs of every Pod
+// matching labelSelector in namespace to out. If prefix is true, each line
+// is prefixed with "<pod name>: ". jobName is used only to label metrics.
+func NewPodWatcher(client kubernetes.Interface, namespace, jobName, labelSelector string, out io.Writer, prefix bool) *PodWatcher {
+	return &PodWatcher{
+		client:        client,
+		namespace:     namespace,
+		jobName:       jobName,
+		labelSelector: labelSelector,
+		out:           &concurrentWriter{w: out},
+		prefix:        prefix,
+		state:         map[string]coreapi.PodPhase{},
+	}
+}
+
+// Run drives an informer scoped to pw.labelSelector until ctx is cancelled
+// or jobDone reports that the Job itself is finished. jobDone is checked on
+// each Pod event once every observed Pod has reached a terminal phase, and
+// also every jobDonePollInterval whatever the Pods' phases, so Run can
+// return while some observed Pods are not yet terminal. Using an informer
+// instead of a raw Watch call means reconnects after a dropped watch are
+// handled by client-go's own backoff, not a hand-rolled reopen loop. It
+// returns ctx's error or the error from jobDone, if any; log streaming
+// errors are logged, not returned.
+func (pw *PodWatcher) Run(ctx context.Context, jobDone func(context.Context) (bool, error)) error {
+	lw := cache.NewFilteredListWatchFromClient(
+		pw.client.CoreV1().RESTClient(), "pods", pw.namespace,
+		func(opts *metav1.ListOptions) { opts.LabelSelector = pw.labelSelector },
+	)
+	informer := cache.NewSharedInformer(lw, &coreapi.Pod{}, 0)
+
+	// stopCh is scoped to this single Run call, not to ctx, which spans the
+	// whole watch daemon's lifetime: monitor() calls Run once per Job, so an
+	// informer tied to ctx.Done() would never stop until the process exits,
+	// leaking a list-watch connection and goroutine per Job monitored. It's
+	// also closed if ctx itself is cancelled first, so a WaitForCacheSync
+	// that's still pending when the program shuts down doesn't hang.
+	stopCh := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(stopCh) }) }
+	defer stop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+		case <-stopCh:
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		tailed   = map[string]bool{}
+		tailedMu sync.Mutex
+	)
+	startTail := func(pod *coreapi.Pod) {
+		tailedMu.Lock()
+		defer tailedMu.Unlock()
+		if tailed[pod.Name] {
+			return
+		}
+		tailed[pod.Name] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pw.tailWithBackoff(ctx, pod.Name)
+		}()
+	}
+
+	// errCh carries the first terminal result from jobDone: nil once the Job
+	// is finished, or a non-nil error if jobDone itself failed.
+	errCh := make(chan error, 1)
+	reportDone := func() {
+		done, err := jobDone(ctx)
+		if err == nil && !done {
+			return
+		}
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+	onPodEvent := func(obj interface{}) {
+		pod, ok := obj.(*coreapi.Pod)
+		if !ok {
+			return
+		}
+		pw.setPhase(pod.Name, pod.Status.Phase)
+		if pod.Status.Phase == coreapi.PodRunning {
+			startTail(pod)
+		}
+		if pw.allTerminal() {
+			reportDone()
+		}
+	}
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onPodEvent,
+		UpdateFunc: func(_, obj interface{}) { onPodEvent(obj) },
+	}); err != nil {
+		return fmt.Errorf("registering pod event handler: %w", err)
+	}
+
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		apiErrorsTotal.WithLabelValues("watch_pods").Inc()
+		stop()
+		wg.Wait()
+		return fmt.Errorf("timed out waiting for pod informer cache to sync")
+	}
+
+	// Pod events aren't enough on their own to notice the Job is done: the
+	// Job controller's own watch can update .status.conditions after kmon's
+	// last Pod has already gone terminal, and a Job that never manages to
+	// create a Pod (e.g. BackoffLimitExceeded from repeated admission
+	// rejections) sends no Pod events at all. Poll jobDone on a timer so
+	// either case is still caught.
+	pollTicker := time.NewTicker(jobDonePollInterval)
+	defer pollTicker.Stop()
+	go func() {
+		for {
+			select {
+			case <-pollTicker.C:
+				reportDone()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		runErr = ctx.Err()
+	case runErr = <-errCh:
+	}
+	stop()
+
+	// Give in-flight log streams a bounded window to flush before returning,
+	// rather than either blocking forever on a wedged stream or abandoning
+	// goroutines that are still writing.
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		log.Printf("timed out waiting for pod log streams to drain")
+	}
+	return runErr
+}
+
+// drainTimeout bounds how long Run waits for in-flight log streams to
+// finish writing once it has decided to return.
+const drainTimeout = 5 * time.Second
+
+// jobDonePollInterval is how often Run re-checks jobDone independent of Pod
+// events, to catch a Job reaching a terminal condition that kmon's own Pod
+// watch can't observe on its own.
+const jobDonePollInterval = 5 * time.Second
+
+func (pw *PodWatcher) setPhase(name string, phase coreapi.PodPhase) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.state[name] = phase
+}
+
+func (pw *PodWatcher) allTerminal() bool {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if len(pw.state) == 0 {
+		return false
+	}
+	for _, phase := range pw.state {
+		if phase != coreapi.PodSucceeded && phase != coreapi.PodFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// tailWithBackoff streams podName's logs to pw.out, reconnecting with
+// exponential backoff on recoverable errors and giving up once the pod has
+// reached a terminal phase.
+func (pw *PodWatcher) tailWithBackoff(ctx context.Context, podName string) {
+	pods := pw.client.CoreV1().Pods(pw.namespace)
+	backoff := 250 * time.Millisecond
+	const maxBackoff = 10 * time.Second
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		stream, err := pods.GetLogs(podName, &coreapi.PodLogOptions{Follow: true}).Stream(ctx)
+		if err != nil {
+			apiErrorsTotal.WithLabelValues("get_logs").Inc()
+			if !isRecoverableStreamErr(err) {
+				log.Printf("giving up tailing %s: %v", podName, err)
+				return
+			}
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff, maxBackoff)
+			continue
+		}
+		backoff = 250 * time.Millisecond
+
+		var dst io.Writer = pw.out
+		if pw.prefix {
+			dst = &prefixWriter{pod: podName, w: pw.out}
+		}
+		n, copyErr := io.Copy(dst, stream)
+		stream.Close()
+		podLogBytesTotal.WithLabelValues(pw.jobName, pw.namespace).Add(float64(n))
+
+		if copyErr != nil && copyErr != io.EOF {
+			if !isRecoverableStreamErr(copyErr) {
+				log.Printf("giving up tailing %s: %v", podName, copyErr)
+				return
+			}
+		}
+
+		pod, err := pods.Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return
+			}
+			apiErrorsTotal.WithLabelValues("get_pod").Inc()
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff, maxBackoff)
+			continue
+		}
+		if pod.Status.Phase == coreapi.PodSucceeded || pod.Status.Phase == coreapi.PodFailed {
+			return
+		}
+		// The stream ended but the Pod is still running (e.g. a transient
+		// connection reset); reconnect.
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff, maxBackoff)
+	}
+}
+
+// isRecoverableStreamErr reports whether err is expected to clear on its
+// own (the container hasn't started yet, or the log stream was cut before
+// the Pod finished) as opposed to a fatal error such as the Pod or
+// namespace no longer existing.
+func isRecoverableStreamErr(err error) bool {
+	if err == nil || err == io.EOF {
+		return true
+	}
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "ContainerCreating") ||
+		strings.Contains(msg, "waiting to start") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "unexpected EOF")
+}
+
+func nextBackoff(cur, max time.Duration) time.Duration {
+	next := cur * 2
+	if next > max {
+		return max
+	}
+	return next
+}
+
+// prefixWriter prefixes every line written to it with "<pod>: " before
+// forwarding to w.
+type prefixWriter struct {
+	pod string
+	w   io.Writer
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	lines := strings.SplitAfter(string(p), "\n")
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(pw.w, "%s: %s", pw.pod, line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsRecoverableStreamErr(t *testing.T) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "my-pod")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: true},
+		{name: "EOF", err: io.EOF, want: true},
+		{name: "container creating", err: errors.New(`container "main" in pod "p" is waiting to start: ContainerCreating`), want: true},
+		{name: "connection reset", err: errors.New("read tcp: connection reset by peer"), want: true},
+		{name: "unexpected EOF", err: errors.New("unexpected EOF"), want: true},
+		{name: "not found is fatal", err: notFound, want: false},
+		{name: "unrelated error is fatal", err: errors.New("tls: bad certificate"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRecoverableStreamErr(tt.err); got != tt.want {
+				t.Errorf("isRecoverableStreamErr(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		cur  time.Duration
+		max  time.Duration
+		want time.Duration
+	}{
+		{name: "doubles under the cap", cur: 250 * time.Millisecond, max: 10 * time.Second, want: 500 * time.Millisecond},
+		{name: "clamps at the cap", cur: 8 * time.Second, max: 10 * time.Second, want: 10 * time.Second},
+		{name: "clamps once past the cap", cur: 20 * time.Second, max: 10 * time.Second, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextBackoff(tt.cur, tt.max); got != tt.want {
+				t.Errorf("nextBackoff(%v, %v) = %v, want %v", tt.cur, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := &prefixWriter{pod: "my-pod", w: &buf}
+
+	n, err := pw.Write([]byte("line one\nline two\n"))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if n != len("line one\nline two\n") {
+		t.Errorf("n = %d, want %d", n, len("line one\nline two\n"))
+	}
+	want := "my-pod: line one\nmy-pod: line two\n"
+	if got := buf.String(); got != want {
+		t.Errorf("buf = %q, want %q", got, want)
+	}
+}
+
+func TestConcurrentWriterSerializesWrites(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &concurrentWriter{w: &buf}
+
+	const writers = 20
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			if _, err := cw.Write([]byte("x\n")); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		<-done
+	}
+	if got := buf.Len(); got != writers*2 {
+		t.Errorf("buf.Len() = %d, want %d (a torn write would corrupt the count)", got, writers*2)
+	}
+}
+
+func TestPodWatcherAllTerminal(t *testing.T) {
+	pw := NewPodWatcher(nil, "ns", "job", "job=my-job", io.Discard, false)
+
+	if pw.allTerminal() {
+		t.Fatal("allTerminal() = true before any pod is observed, want false")
+	}
+
+	pw.setPhase("pod-a", "Running")
+	if pw.allTerminal() {
+		t.Fatal("allTerminal() = true with a running pod, want false")
+	}
+
+	pw.setPhase("pod-a", "Succeeded")
+	if !pw.allTerminal() {
+		t.Fatal("allTerminal() = false with every pod terminal, want true")
+	}
+
+	pw.setPhase("pod-b", "Running")
+	if pw.allTerminal() {
+		t.Fatal("allTerminal() = true with a second, running pod, want false")
+	}
+}
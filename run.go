@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	batchv1 "k8s.io/api/batch/v1"
+	coreapi "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	batchtypedv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+// cleanupMode controls whether runMain deletes the Job it created once the
+// Job has finished.
+type cleanupMode string
+
+const (
+	cleanupAlways    cleanupMode = "true"
+	cleanupNever     cleanupMode = "false"
+	cleanupOnSuccess cleanupMode = "on-success"
+)
+
+func (m *cleanupMode) String() string {
+	if m == nil || *m == "" {
+		return string(cleanupOnSuccess)
+	}
+	return string(*m)
+}
+
+func (m *cleanupMode) Set(s string) error {
+	switch cleanupMode(s) {
+	case cleanupAlways, cleanupNever, cleanupOnSuccess:
+		*m = cleanupMode(s)
+		return nil
+	default:
+		return fmt.Errorf("invalid --cleanup value %q (want true, false, or on-success)", s)
+	}
+}
+
+// runMain implements the "run" subcommand: apply a Job manifest, tail its
+// logs, and exit with a code that reflects the Job's own terminal status.
+// It's meant to make kmon usable as a one-shot "run a Job, get its logs, get
+// its exit code" step in a CI pipeline.
+func runMain(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+
+	var kubeconfig *string
+	if home := homedir.HomeDir(); home != "" {
+		kubeconfig = fs.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+	} else {
+		kubeconfig = fs.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	}
+	var (
+		namespace    string
+		jobFile      string
+		image        string
+		argsOverride StringSlice
+		env          StringSlice
+		cleanup      = cleanupOnSuccess
+		sinkSpec     string
+		logDir       string
+	)
+	fs.StringVar(&namespace, "n", "", "Kubernetes namespace (required).")
+	fs.StringVar(&jobFile, "f", "", "Path to a Job manifest, YAML or JSON (required).")
+	fs.StringVar(&image, "image", "", "Override the first container's image.")
+	fs.Var(&argsOverride, "arg", "Override the first container's args (can be provided multiple times).")
+	fs.Var(&env, "env", "Set an environment variable as KEY=VAL (can be provided multiple times).")
+	fs.Var(&cleanup, "cleanup", "Whether to delete the job once it finishes: true, false, or on-success (default on-success).")
+	fs.StringVar(&sinkSpec, "sink", "file", "Where to send pod logs and status: file, stdout, rotate, or s3://bucket/prefix.")
+	fs.StringVar(&logDir, "log-dir", "", "Directory to write logs and status to when --sink=file (default: current directory).")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if jobFile == "" {
+		log.Print("run: -f is required")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		log.Printf("building kubeconfig: %v", err)
+		return 1
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Printf("building kubernetes client: %v", err)
+		return 1
+	}
+	logSink, statusSink, err := parseSinkSpec(sinkSpec, logDir)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	exitCode, err := runJob(ctx, client, namespace, jobFile, image, argsOverride, env, cleanup, logSink, statusSink)
+	if err != nil {
+		log.Print(err)
+	}
+	return exitCode
+}
+
+// runJob decodes the Job manifest at jobFile, applies any image/arg/env
+// overrides, creates it, and reuses monitor to tail its logs until it
+// finishes. It returns an exit code suitable for a CI pipeline: 0 if the
+// Job reached JobComplete, 1 if it reached JobFailed.
+func runJob(ctx context.Context, client *kubernetes.Clientset, namespace, jobFile, image string, argsOverride, env StringSlice, cleanup cleanupMode, logSink LogSink, statusSink StatusSink) (int, error) {
+	job, err := decodeJob(jobFile, image, argsOverride, env)
+	if err != nil {
+		return 1, err
+	}
+
+	jobs := client.BatchV1().Jobs(namespace)
+	created, err := jobs.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return 1, fmt.Errorf("creating job: %w", err)
+	}
+	log.Printf("created job %s", created.Name)
+
+	runErr := monitor(ctx, client, namespace, created.Name, logSink, statusSink)
+
+	exitCode, statusErr := jobExitCode(ctx, client, namespace, created.Name)
+	if statusErr != nil {
+		if runErr == nil {
+			runErr = statusErr
+		}
+		exitCode = 1
+	}
+
+	switch cleanup {
+	case cleanupAlways:
+		deleteJob(ctx, jobs, created.Name)
+	case cleanupOnSuccess:
+		if exitCode == 0 {
+			deleteJob(ctx, jobs, created.Name)
+		}
+	}
+	return exitCode, runErr
+}
+
+// decodeJob reads a Job manifest from path and applies the given overrides
+// to its first container.
+func decodeJob(path, image string, argsOverride, env StringSlice) (*batchv1.Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening job file: %w", err)
+	}
+	defer f.Close()
+
+	var job batchv1.Job
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&job); err != nil {
+		return nil, fmt.Errorf("decoding job manifest: %w", err)
+	}
+	if len(job.Spec.Template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("job manifest has no containers")
+	}
+	container := &job.Spec.Template.Spec.Containers[0]
+	if image != "" {
+		container.Image = image
+	}
+	if len(argsOverride) > 0 {
+		container.Args = argsOverride
+	}
+	for _, kv := range env {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --env value %q (want KEY=VAL)", kv)
+		}
+		container.Env = append(container.Env, coreapi.EnvVar{Name: k, Value: v})
+	}
+	return &job, nil
+}
+
+// jobExitCode re-fetches name's terminal status and returns 1 if it
+// reached JobFailed, 0 if it reached JobComplete. It mirrors the condition
+// check monitor's jobDone callback uses to decide the Job is finished,
+// rather than inferring the outcome from historical Pods: a Job with
+// backoffLimit > 0 keeps earlier failed-attempt Pods around even after a
+// later attempt succeeds, and a manifest with no top-level .metadata.labels
+// would otherwise list every Pod in the namespace.
+func jobExitCode(ctx context.Context, client *kubernetes.Clientset, namespace, name string) (int, error) {
+	final, err := client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		apiErrorsTotal.WithLabelValues("get_job").Inc()
+		return 1, fmt.Errorf("getting job: %w", err)
+	}
+	for _, cond := range final.Status.Conditions {
+		if cond.Status != coreapi.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobFailed:
+			return 1, nil
+		case batchv1.JobComplete:
+			return 0, nil
+		}
+	}
+	return 1, fmt.Errorf("job %s has no terminal condition", name)
+}
+
+func deleteJob(ctx context.Context, jobs batchtypedv1.JobInterface, name string) {
+	policy := metav1.DeletePropagationBackground
+	if err := jobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &policy}); err != nil {
+		log.Printf("deleting job %s: %v", name, err)
+	}
+}
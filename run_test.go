@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testManifest = `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: my-job
+spec:
+  template:
+    spec:
+      containers:
+      - name: main
+        image: busybox:1.36
+        args: ["echo", "hello"]
+      restartPolicy: Never
+`
+
+func writeTestManifest(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing test manifest: %v", err)
+	}
+	return path
+}
+
+func TestDecodeJob(t *testing.T) {
+	path := writeTestManifest(t, testManifest)
+
+	t.Run("no overrides", func(t *testing.T) {
+		job, err := decodeJob(path, "", nil, nil)
+		if err != nil {
+			t.Fatalf("decodeJob: %v", err)
+		}
+		container := job.Spec.Template.Spec.Containers[0]
+		if container.Image != "busybox:1.36" {
+			t.Errorf("Image = %q, want %q", container.Image, "busybox:1.36")
+		}
+		if got := container.Args; len(got) != 2 || got[0] != "echo" || got[1] != "hello" {
+			t.Errorf("Args = %v, want [echo hello]", got)
+		}
+	})
+
+	t.Run("image override", func(t *testing.T) {
+		job, err := decodeJob(path, "busybox:1.37", nil, nil)
+		if err != nil {
+			t.Fatalf("decodeJob: %v", err)
+		}
+		if got := job.Spec.Template.Spec.Containers[0].Image; got != "busybox:1.37" {
+			t.Errorf("Image = %q, want %q", got, "busybox:1.37")
+		}
+	})
+
+	t.Run("args override", func(t *testing.T) {
+		job, err := decodeJob(path, "", StringSlice{"sleep", "10"}, nil)
+		if err != nil {
+			t.Fatalf("decodeJob: %v", err)
+		}
+		got := job.Spec.Template.Spec.Containers[0].Args
+		if len(got) != 2 || got[0] != "sleep" || got[1] != "10" {
+			t.Errorf("Args = %v, want [sleep 10]", got)
+		}
+	})
+
+	t.Run("env override", func(t *testing.T) {
+		job, err := decodeJob(path, "", nil, StringSlice{"FOO=bar", "BAZ=qux"})
+		if err != nil {
+			t.Fatalf("decodeJob: %v", err)
+		}
+		env := job.Spec.Template.Spec.Containers[0].Env
+		if len(env) != 2 {
+			t.Fatalf("len(Env) = %d, want 2", len(env))
+		}
+		if env[0].Name != "FOO" || env[0].Value != "bar" {
+			t.Errorf("Env[0] = %+v, want FOO=bar", env[0])
+		}
+		if env[1].Name != "BAZ" || env[1].Value != "qux" {
+			t.Errorf("Env[1] = %+v, want BAZ=qux", env[1])
+		}
+	})
+
+	t.Run("invalid env value", func(t *testing.T) {
+		_, err := decodeJob(path, "", nil, StringSlice{"not-a-kv-pair"})
+		if err == nil {
+			t.Fatal("decodeJob: expected an error for a malformed --env value, got nil")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := decodeJob(filepath.Join(t.TempDir(), "does-not-exist.yaml"), "", nil, nil)
+		if err == nil {
+			t.Fatal("decodeJob: expected an error for a missing manifest, got nil")
+		}
+	})
+
+	t.Run("no containers", func(t *testing.T) {
+		path := writeTestManifest(t, `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: my-job
+spec:
+  template:
+    spec:
+      containers: []
+`)
+		_, err := decodeJob(path, "", nil, nil)
+		if err == nil {
+			t.Fatal("decodeJob: expected an error for a manifest with no containers, got nil")
+		}
+	})
+}
+
+func TestCleanupModeSet(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: "true", wantErr: false},
+		{in: "false", wantErr: false},
+		{in: "on-success", wantErr: false},
+		{in: "always", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		var m cleanupMode
+		err := m.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && m.String() != tt.in {
+			t.Errorf("after Set(%q), String() = %q, want %q", tt.in, m.String(), tt.in)
+		}
+	}
+}
+
+func TestCleanupModeStringDefault(t *testing.T) {
+	var m cleanupMode
+	if got := m.String(); got != string(cleanupOnSuccess) {
+		t.Errorf("String() on zero value = %q, want %q", got, cleanupOnSuccess)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// LogSink opens a destination for a Job or Pod's log lines. Open may be
+// called more than once for the same name (e.g. if a Pod is retried);
+// implementations should treat each call as a fresh stream.
+type LogSink interface {
+	Open(name string) (io.WriteCloser, error)
+}
+
+// StatusSink persists the final status of a Job or Pod, keyed by name.
+type StatusSink interface {
+	Write(name string, v interface{}) error
+}
+
+// parseSinkSpec builds the LogSink/StatusSink pair for --sink. Supported
+// forms are "file" (the default, writing under logDir), "stdout", "rotate"
+// (like "file" but with size/age-based rotation), and "s3://bucket/prefix".
+func parseSinkSpec(spec, logDir string) (LogSink, StatusSink, error) {
+	switch {
+	case spec == "" || spec == "file":
+		return &fileLogSink{dir: logDir}, &fileStatusSink{dir: logDir}, nil
+
+	case spec == "stdout":
+		out := &concurrentWriter{w: os.Stdout}
+		return &stdoutLogSink{out: out}, &stdoutStatusSink{out: out}, nil
+
+	case spec == "rotate":
+		const (
+			defaultMaxSize = 100 * 1024 * 1024
+			defaultMaxAge  = 24 * time.Hour
+		)
+		return &rotatingFileSink{dir: logDir, maxSize: defaultMaxSize, maxAge: defaultMaxAge}, &fileStatusSink{dir: logDir}, nil
+
+	case strings.HasPrefix(spec, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(spec, "s3://"), "/")
+		if bucket == "" {
+			return nil, nil, fmt.Errorf("invalid --sink %q: missing bucket", spec)
+		}
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading AWS config: %w", err)
+		}
+		client := s3.NewFromConfig(cfg)
+		sink := &s3Sink{bucket: bucket, prefix: prefix, uploader: manager.NewUploader(client)}
+		return sink, sink, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown --sink %q (want file, stdout, rotate, or s3://bucket/prefix)", spec)
+	}
+}
+
+// fileLogSink writes each name's logs to "<dir>/<name>.logs", the original
+// behavior of kmon.
+type fileLogSink struct {
+	dir string
+}
+
+func (s *fileLogSink) Open(name string) (io.WriteCloser, error) {
+	if s.dir != "" {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating log dir: %w", err)
+		}
+	}
+	f, err := os.Create(filepath.Join(s.dir, name+".logs"))
+	if err != nil {
+		return nil, fmt.Errorf("creating log file: %w", err)
+	}
+	return f, nil
+}
+
+// fileStatusSink writes name's status as JSON to "<dir>/<name>.json".
+type fileStatusSink struct {
+	dir string
+}
+
+func (s *fileStatusSink) Write(name string, v interface{}) error {
+	if s.dir != "" {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return fmt.Errorf("creating log dir: %w", err)
+		}
+	}
+	f, err := os.Create(filepath.Join(s.dir, name+".json"))
+	if err != nil {
+		return fmt.Errorf("creating status file: %w", err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		return fmt.Errorf("writing status file: %w", err)
+	}
+	return nil
+}
+
+// stdoutLogSink streams every name's logs to a single writer with each line
+// prefixed by the name, so multiple concurrent streams stay legible.
+type stdoutLogSink struct {
+	out *concurrentWriter
+}
+
+func (s *stdoutLogSink) Open(name string) (io.WriteCloser, error) {
+	return nopCloser{&prefixWriter{pod: name, w: s.out}}, nil
+}
+
+// stdoutStatusSink writes name's status to stdout as a single line of the
+// form "<name>: <JSON>".
+type stdoutStatusSink struct {
+	out *concurrentWriter
+}
+
+func (s *stdoutStatusSink) Write(name string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling status: %w", err)
+	}
+	_, err = fmt.Fprintf(s.out, "%s: %s\n", name, body)
+	return err
+}
+
+// nopCloser adapts an io.Writer that doesn't need closing to io.WriteCloser.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// rotatingFileSink wraps fileLogSink's behavior with size- and age-based
+// rotation: once a write would take the current file past maxSize bytes or
+// maxAge has elapsed since it was opened, it's closed and a new one takes its
+// place. Files are named "<dir>/<name>.<n>.logs", with n counting up from 1.
+type rotatingFileSink struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+}
+
+func (s *rotatingFileSink) Open(name string) (io.WriteCloser, error) {
+	if s.dir != "" {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating log dir: %w", err)
+		}
+	}
+	rf := &rotatingFile{
+		dir:     s.dir,
+		name:    name,
+		maxSize: s.maxSize,
+		maxAge:  s.maxAge,
+	}
+	if err := rf.rotate(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+type rotatingFile struct {
+	dir     string
+	name    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+	rotated int
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotateLocked(); err != nil {
+			return 0, err
+		}
+	} else if rf.maxAge > 0 && time.Since(rf.opened) > rf.maxAge {
+		if err := rf.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotateLocked()
+}
+
+func (rf *rotatingFile) rotateLocked() error {
+	if rf.f != nil {
+		rf.f.Close()
+	}
+	rf.rotated++
+	filename := fmt.Sprintf("%s.%d.logs", rf.name, rf.rotated)
+	f, err := os.Create(filepath.Join(rf.dir, filename))
+	if err != nil {
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	rf.f = f
+	rf.size = 0
+	rf.opened = time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	return rf.f.Close()
+}
+
+// s3Sink streams each name's logs to "s3://bucket/prefix/name.logs" and
+// writes its status to "s3://bucket/prefix/name.json", both via the S3
+// multipart upload manager. Logs are streamed without buffering the whole
+// object in memory; the status is marshalled in full before it is uploaded.
+type s3Sink struct {
+	bucket   string
+	prefix   string
+	uploader *manager.Uploader
+}
+
+func (s *s3Sink) key(name, ext string) string {
+	return strings.TrimPrefix(filepath.Join(s.prefix, name+ext), "/")
+}
+
+func (s *s3Sink) Open(name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: &s.bucket,
+			Key:    awsString(s.key(name, ".logs")),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return &s3Writer{pw: pw, done: done}, nil
+}
+
+func (s *s3Sink) Write(name string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling status: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+	_, err = s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: &s.bucket,
+		Key:    awsString(s.key(name, ".json")),
+		Body:   strings.NewReader(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading status: %w", err)
+	}
+	return nil
+}
+
+// s3UploadTimeout bounds the status PUT in s3Sink.Write, which unlike the
+// streaming log upload in s3Sink.Open is a single small object with no
+// natural end signal of its own to wait on.
+const s3UploadTimeout = 30 * time.Second
+
+// s3Writer adapts the io.Pipe feeding an in-flight multipart upload to
+// io.WriteCloser: Close waits, up to drainTimeout, for the upload to finish
+// so callers know the object is durable before moving on.
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close waits up to drainTimeout for the upload to finish after closing the
+// pipe, rather than blocking forever if the upload has stalled (e.g. an
+// unreachable S3 endpoint) — the same bounded-shutdown guarantee PodWatcher
+// gives its own log-tailing goroutines.
+func (w *s3Writer) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	select {
+	case err := <-w.done:
+		return err
+	case <-time.After(drainTimeout):
+		return fmt.Errorf("timed out waiting for s3 upload to finish")
+	}
+}
+
+func awsString(s string) *string { return &s }